@@ -0,0 +1,16 @@
+package core
+
+// AuditEvent describes a single authentication-relevant occurrence, for
+// wiring up Prometheus counters, ELK shipping, or similar.
+type AuditEvent struct {
+	Type     string // e.g. "auth.success", "auth.failure", "challenge.issued"
+	Provider string
+	User     string
+	Remote   string
+	Err      error
+}
+
+// AuditLogger is notified of authentication events as they happen.
+type AuditLogger interface {
+	Audit(event AuditEvent)
+}
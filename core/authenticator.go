@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"net/http"
+)
+
+// Authenticator verifies the caller of r and returns their identity. It's
+// the contract providers.BasicAuth and friends implement so IDP doesn't
+// need to know how a challenge gets fulfilled.
+type Authenticator interface {
+	// Check verifies r and returns the authenticated user, or an error if
+	// authentication hasn't succeeded yet.
+	Check(r *http.Request) (user string, err error)
+
+	// Respond drives whatever's needed to let the caller try again, e.g.
+	// a WWW-Authenticate challenge or a login form.
+	Respond(w http.ResponseWriter, r *http.Request) error
+}
+
+type chainFailureKey struct{}
+
+// authenticatorChain requires every wrapped Authenticator to succeed, and
+// to agree on who the user is.
+type authenticatorChain struct {
+	providers []Authenticator
+}
+
+// Chain composes providers into a single Authenticator that only succeeds
+// once every one of them has, e.g. a password followed by a WebAuthn
+// assertion as a second factor.
+func Chain(providers ...Authenticator) Authenticator {
+	return &authenticatorChain{providers: providers}
+}
+
+func (c *authenticatorChain) Check(r *http.Request) (user string, err error) {
+	for _, p := range c.providers {
+		u, err := p.Check(r)
+		if err != nil {
+			rememberChainFailure(r, p)
+			return "", err
+		}
+
+		if user != "" && u != user {
+			return "", ErrorAuthenticationFailure
+		}
+		user = u
+	}
+
+	return user, nil
+}
+
+// Respond shows whichever provider Check last found unsatisfied. It relies
+// on Check having run first on the same r - it does not call Check itself,
+// since providers like BasicAuth count every Check as an attempt, and
+// calling it twice per request would halve their effective failure budget.
+func (c *authenticatorChain) Respond(w http.ResponseWriter, r *http.Request) error {
+	if p, ok := chainFailure(r); ok {
+		return p.Respond(w, r)
+	}
+
+	if len(c.providers) > 0 {
+		return c.providers[0].Respond(w, r)
+	}
+
+	return nil
+}
+
+// rememberChainFailure stashes which provider rejected r in its context,
+// mutating the request in place so Respond can read it back without
+// needing its own copy of r.
+func rememberChainFailure(r *http.Request, p Authenticator) {
+	*r = *r.WithContext(context.WithValue(r.Context(), chainFailureKey{}, p))
+}
+
+func chainFailure(r *http.Request) (Authenticator, bool) {
+	p, ok := r.Context().Value(chainFailureKey{}).(Authenticator)
+	return p, ok
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// consentResponseTTL is how long the signed consent JWT is valid for, on
+// its short hop back to Hydra's /oauth2/auth?consent=.
+const consentResponseTTL = time.Minute
+
+// Challenge represents an inbound OAuth2 authorization request that Hydra
+// is asking this IDP to resolve: authenticate User and decide whether
+// Client may have Scopes.
+type Challenge struct {
+	Client   string
+	Redirect string
+	Scopes   []string
+	User     string
+	Expires  time.Time
+
+	idp *IDP
+}
+
+// ChallengeClaims are the claims Hydra signs into the inbound challenge
+// JWT passed as the "challenge" form value.
+type ChallengeClaims struct {
+	jwt.StandardClaims
+
+	Redirect string   `json:"redir"`
+	Scopes   []string `json:"scp"`
+}
+
+// ConsentClaims are the claims GrantResponse/DenyResponse sign into the
+// outbound consent JWT that's redirected back to Hydra's
+// /oauth2/auth?consent=<jwt>.
+type ConsentClaims struct {
+	jwt.StandardClaims
+
+	Scopes           []string    `json:"scp,omitempty"`
+	Subject          string      `json:"sub,omitempty"`
+	AccessTokenExtra interface{} `json:"at_ext,omitempty"`
+	IDTokenExtra     interface{} `json:"id_ext,omitempty"`
+
+	// Error is set by DenyResponse to tell Hydra why consent was refused.
+	Error string `json:"error,omitempty"`
+}
+
+// GrantResponse signs a consent JWT granting subject the requested scopes,
+// for redirecting back to Hydra's /oauth2/auth?consent=<jwt>. If
+// idTokenExtra is nil and the IDP has a ClaimsProvider configured, its
+// claims for subject/scopes are used instead of leaving id_ext empty.
+func (c *Challenge) GrantResponse(subject string, scopes []string, accessTokenExtra, idTokenExtra interface{}) (signedJWT string, err error) {
+	if idTokenExtra == nil && c.idp.config.ClaimsProvider != nil {
+		idTokenExtra, err = c.idp.config.ClaimsProvider.Claims(subject, scopes)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	jti, err := randomJTI()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &ConsentClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Audience:  c.Client,
+			ExpiresAt: time.Now().Add(consentResponseTTL).Unix(),
+		},
+		Scopes:           scopes,
+		Subject:          subject,
+		AccessTokenExtra: accessTokenExtra,
+		IDTokenExtra:     idTokenExtra,
+	}
+
+	return c.sign(claims)
+}
+
+// DenyResponse signs a consent JWT telling Hydra that consent was refused,
+// carrying reason for it to show the client.
+func (c *Challenge) DenyResponse(reason string) (signedJWT string, err error) {
+	jti, err := randomJTI()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &ConsentClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Audience:  c.Client,
+			ExpiresAt: time.Now().Add(consentResponseTTL).Unix(),
+		},
+		Error: reason,
+	}
+
+	return c.sign(claims)
+}
+
+func (c *Challenge) sign(claims *ConsentClaims) (string, error) {
+	key, err := c.idp.GetConsentKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = c.idp.ConsentKeyID()
+
+	return token.SignedString(key)
+}
+
+func randomJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,9 @@
+package core
+
+// IDTokenClaimsProvider supplies the claims that go into an OIDC ID token
+// for user, given the scopes the client was granted. Implementations
+// typically map profile data onto the standard OIDC scope-to-claim
+// mapping (profile, email, groups, ...).
+type IDTokenClaimsProvider interface {
+	Claims(user string, scopes []string) (map[string]interface{}, error)
+}
@@ -0,0 +1,9 @@
+package core
+
+import "errors"
+
+// ErrorAccountLocked is returned by BasicAuth.Check once an account (or
+// the IP attempting it) has exceeded its allowed number of failed
+// attempts. It's distinct from ErrorAuthenticationFailure so callers can
+// tell "wrong credentials" and "try again later" apart.
+var ErrorAccountLocked = errors.New("account locked")
@@ -3,22 +3,22 @@ package core
 import (
 	"crypto/rsa"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/sessions"
-	"github.com/mendsley/gojwk"
 	"github.com/patrickmn/go-cache"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
-	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
 const (
-	VerifyPublicKey   = "VerifyPublic"
-	ConsentPrivateKey = "ConsentPrivate"
+	consentKeyCacheKey   = "ConsentPrivate"
+	verifyKeyCachePrefix = "VerifyPublic:"
 )
 
 var encryptionkey = "something-very-secret"
@@ -30,6 +30,18 @@ type IDPConfig struct {
 	KeyCacheExpiration      time.Duration `yaml:"key_cache_expiration"`
 	KeyCacheCleanupInterval time.Duration `yaml:"key_cache_cleanup_interval"`
 	ChallengeStore          sessions.Store
+
+	// KeyManager supplies verification/consent keys. If nil, Connect falls
+	// back to a HydraKeyManager built from HydraAddress.
+	KeyManager KeyManager
+
+	// ClaimsProvider looks up the ID token claims for a user. If set,
+	// Challenge.GrantResponse uses it to fill in the ID token extra claims
+	// whenever the caller doesn't supply its own.
+	ClaimsProvider IDTokenClaimsProvider
+
+	// AuditLogger, if set, is notified of challenge-handling events.
+	AuditLogger AuditLogger
 }
 
 type IDP struct {
@@ -38,6 +50,12 @@ type IDP struct {
 	// Http client for communicating with Hydra
 	client *http.Client
 
+	// Source of verification/consent keys
+	keyManager KeyManager
+
+	// kid of the currently active consent signing key
+	consentKID string
+
 	// Cache for all private and public keys
 	keyCache *cache.Cache
 }
@@ -53,81 +71,30 @@ func NewIDP(config *IDPConfig) *IDP {
 	return idp
 }
 
-// Called when key expires
+func verifyKeyCacheKey(kid string) string {
+	return verifyKeyCachePrefix + kid
+}
+
+// Called when a cached key expires
 func (idp *IDP) refreshKeyCache(key string) {
-	switch key {
-	case VerifyPublicKey:
-		verifyKey, err := idp.getVerificationKey()
+	if key == consentKeyCacheKey {
+		consentKey, kid, err := idp.keyManager.ConsentKey()
 		if err != nil {
 			return
 		}
-		idp.keyCache.Set(VerifyPublicKey, verifyKey, cache.DefaultExpiration)
+		idp.keyCache.Set(consentKeyCacheKey, consentKey, cache.DefaultExpiration)
+		idp.consentKID = kid
 		return
+	}
 
-	case ConsentPrivateKey:
-		consentKey, err := idp.getConsentKey()
+	if kid := strings.TrimPrefix(key, verifyKeyCachePrefix); kid != key {
+		verifyKey, err := idp.keyManager.VerificationKey(kid)
 		if err != nil {
 			return
 		}
-		idp.keyCache.Set(ConsentPrivateKey, consentKey, cache.DefaultExpiration)
+		idp.keyCache.Set(key, verifyKey, cache.DefaultExpiration)
 		return
-
-	default:
-		return
-	}
-}
-
-// Gets the requested key from Hydra
-func (idp *IDP) getKey(set string, kind string) (*gojwk.Key, error) {
-	url := idp.config.HydraAddress + "/keys/" + set + "/" + kind
-
-	resp, err := idp.client.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := gojwk.Unmarshal(body)
-	if err != nil {
-		return nil, err
-	}
-
-	return key.Keys[0], nil
-}
-
-// Downloads the hydra's public key
-func (idp *IDP) getVerificationKey() (*rsa.PublicKey, error) {
-	jwk, err := idp.getKey("consent.challenge", "public")
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := jwk.DecodePublicKey()
-	if err != nil {
-		return nil, err
 	}
-
-	return key.(*rsa.PublicKey), err
-}
-
-// Downloads the private key used for signing the consent
-func (idp *IDP) getConsentKey() (*rsa.PrivateKey, error) {
-	jwk, err := idp.getKey("consent.endpoint", "private")
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := jwk.DecodePrivateKey()
-	if err != nil {
-		return nil, err
-	}
-
-	return key.(*rsa.PrivateKey), err
 }
 
 func (idp *IDP) login() error {
@@ -164,31 +131,40 @@ func (idp *IDP) Connect() error {
 		return err
 	}
 
-	verifyKey, err := idp.getVerificationKey()
+	idp.keyManager = idp.config.KeyManager
+	if idp.keyManager == nil {
+		idp.keyManager = NewHydraKeyManager(idp.config.HydraAddress, idp.client)
+	}
+
+	consentKey, kid, err := idp.keyManager.ConsentKey()
 	if err != nil {
 		return err
 	}
 
-	consentKey, err := idp.getConsentKey()
+	verifyKey, err := idp.keyManager.VerificationKey(kid)
 	if err != nil {
 		return err
 	}
 
-	idp.keyCache.Set(VerifyPublicKey, verifyKey, cache.DefaultExpiration)
-	idp.keyCache.Set(ConsentPrivateKey, consentKey, cache.DefaultExpiration)
+	idp.keyCache.Set(consentKeyCacheKey, consentKey, cache.DefaultExpiration)
+	idp.keyCache.Set(verifyKeyCacheKey(kid), verifyKey, cache.DefaultExpiration)
+	idp.consentKID = kid
 
-	return err
+	return nil
 }
 
-// Parse and verify the challenge JWT
+// Parse and verify the challenge JWT, picking the verification key by the
+// token's kid header so a key rotation doesn't invalidate challenges
+// already in flight.
 func (idp *IDP) getChallengeToken(challengeString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(challengeString, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(challengeString, &ChallengeClaims{}, func(token *jwt.Token) (interface{}, error) {
 		_, ok := token.Method.(*jwt.SigningMethodRSA)
 		if !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return idp.GetVerificationKey()
+		kid, _ := token.Header["kid"].(string)
+		return idp.GetVerificationKey(kid)
 	})
 
 	if err != nil {
@@ -203,7 +179,7 @@ func (idp *IDP) getChallengeToken(challengeString string) (*jwt.Token, error) {
 }
 
 func (idp *IDP) GetConsentKey() (*rsa.PrivateKey, error) {
-	data, ok := idp.keyCache.Get(ConsentPrivateKey)
+	data, ok := idp.keyCache.Get(consentKeyCacheKey)
 	if !ok {
 		return nil, ErrorNoKey
 	}
@@ -216,10 +192,25 @@ func (idp *IDP) GetConsentKey() (*rsa.PrivateKey, error) {
 	return key, nil
 }
 
-func (idp *IDP) GetVerificationKey() (*rsa.PublicKey, error) {
-	data, ok := idp.keyCache.Get(VerifyPublicKey)
+// ConsentKeyID returns the kid of the consent key GetConsentKey currently
+// returns, for tagging JWTs signed with it.
+func (idp *IDP) ConsentKeyID() string {
+	return idp.consentKID
+}
+
+// GetVerificationKey returns the public key matching kid, fetching and
+// caching it through the KeyManager if it isn't cached yet. An empty kid
+// requests the current key.
+func (idp *IDP) GetVerificationKey(kid string) (*rsa.PublicKey, error) {
+	data, ok := idp.keyCache.Get(verifyKeyCacheKey(kid))
 	if !ok {
-		return nil, ErrorNoKey
+		key, err := idp.keyManager.VerificationKey(kid)
+		if err != nil {
+			return nil, ErrorNoKey
+		}
+
+		idp.keyCache.Set(verifyKeyCacheKey(kid), key, cache.DefaultExpiration)
+		return key, nil
 	}
 
 	key, ok := data.(*rsa.PublicKey)
@@ -230,6 +221,22 @@ func (idp *IDP) GetVerificationKey() (*rsa.PublicKey, error) {
 	return key, nil
 }
 
+// JWKSHandler publishes the public half of the consent-signing key (and any
+// keys still valid during rotation) as a standard JWK set, so relying
+// parties can verify consent JWTs without being told the key out of band.
+func (idp *IDP) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := idp.keyManager.PublicJWKS()
+		if err != nil {
+			http.Error(w, "failed to load keys", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		json.NewEncoder(w).Encode(jwks)
+	})
+}
+
 func (idp *IDP) NewChallenge(r *http.Request, user string) (challenge *Challenge, err error) {
 	tokenStr := r.FormValue("challenge")
 	if tokenStr == "" {
@@ -241,34 +248,45 @@ func (idp *IDP) NewChallenge(r *http.Request, user string) (challenge *Challenge
 	token, err := idp.getChallengeToken(tokenStr)
 	if err != nil {
 		// Most probably, token can't be verified or parsed
+		idp.audit("challenge.invalid", user, err)
 		return
 	}
-	claims := token.Claims.(jwt.MapClaims)
+	claims := token.Claims.(*ChallengeClaims)
 
 	challenge = new(Challenge)
-	challenge.Expires = time.Unix(int64(claims["exp"].(float64)), 0)
+	challenge.Expires = time.Unix(claims.ExpiresAt, 0)
 	if challenge.Expires.Before(time.Now()) {
 		challenge = nil
 		err = ErrorChallengeExpired
+		idp.audit("challenge.expired", user, err)
 		return
 	}
 
-	// Get data from the challenge jwt
-	challenge.Client = claims["aud"].(string)
-	challenge.Redirect = claims["redir"].(string)
+	challenge.Client = claims.Audience
+	challenge.Redirect = claims.Redirect
+	challenge.Scopes = claims.Scopes
 
 	challenge.User = user
 	challenge.idp = idp
 
-	scopes := claims["scp"].([]interface{})
-	challenge.Scopes = make([]string, len(scopes), len(scopes))
-	for i, scope := range scopes {
-		challenge.Scopes[i] = scope.(string)
-	}
+	idp.audit("challenge.issued", user, nil)
 
 	return
 }
 
+// audit notifies the configured AuditLogger, if any.
+func (idp *IDP) audit(eventType, user string, err error) {
+	if idp.config.AuditLogger == nil {
+		return
+	}
+
+	idp.config.AuditLogger.Audit(AuditEvent{
+		Type: eventType,
+		User: user,
+		Err:  err,
+	})
+}
+
 func (idp *IDP) GetChallenge(r *http.Request) (*Challenge, error) {
 	session, err := idp.config.ChallengeStore.Get(r, SessionCookieName)
 	if err != nil {
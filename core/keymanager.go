@@ -0,0 +1,291 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mendsley/gojwk"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// KeyManager supplies the keys IDP needs: a public key for verifying
+// inbound challenge JWTs, and a private key (plus its kid) for signing
+// outbound consent JWTs. Putting key acquisition behind this interface lets
+// IDP run against Hydra, a local JWK file, or keys generated in-process,
+// without changing how challenges and consent are handled.
+type KeyManager interface {
+	// VerificationKey returns the public key matching kid. An empty kid
+	// requests the current key.
+	VerificationKey(kid string) (*rsa.PublicKey, error)
+
+	// ConsentKey returns the private key IDP signs consent JWTs with,
+	// together with the kid that should go in the JWT header.
+	ConsentKey() (key *rsa.PrivateKey, kid string, err error)
+
+	// PublicJWKS returns the public half of every key that's still valid
+	// for verification, including ones kept around during rotation, for
+	// publishing on a JWKS endpoint.
+	PublicJWKS() (*jose.JSONWebKeySet, error)
+}
+
+// HydraKeyManager fetches keys from Hydra's /keys endpoint. This is the
+// original key source IDP used before KeyManager existed.
+type HydraKeyManager struct {
+	Address string
+	Client  *http.Client
+}
+
+func NewHydraKeyManager(address string, client *http.Client) *HydraKeyManager {
+	return &HydraKeyManager{
+		Address: address,
+		Client:  client,
+	}
+}
+
+func (h *HydraKeyManager) fetch(set string, kind string) (*gojwk.Key, error) {
+	url := h.Address + "/keys/" + set + "/" + kind
+
+	resp, err := h.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := gojwk.Unmarshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Keys[0], nil
+}
+
+// VerificationKey ignores kid: Hydra only ever hands back its current
+// challenge key.
+func (h *HydraKeyManager) VerificationKey(kid string) (*rsa.PublicKey, error) {
+	jwk, err := h.fetch("consent.challenge", "public")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwk.DecodePublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return key.(*rsa.PublicKey), nil
+}
+
+func (h *HydraKeyManager) ConsentKey() (*rsa.PrivateKey, string, error) {
+	jwk, err := h.fetch("consent.endpoint", "private")
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := jwk.DecodePrivateKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key.(*rsa.PrivateKey), "consent.endpoint", nil
+}
+
+func (h *HydraKeyManager) PublicJWKS() (*jose.JSONWebKeySet, error) {
+	key, kid, err := h.ConsentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: kid, Use: "sig", Algorithm: "RS256"},
+		},
+	}, nil
+}
+
+// FileKeyManager loads a JWK set from disk once, at startup. ConsentKID
+// picks which key in the set is used for signing; every other key (and the
+// public half of the consent key) is available for verification.
+type FileKeyManager struct {
+	ConsentKID string
+
+	mu  sync.RWMutex
+	set jose.JSONWebKeySet
+}
+
+func NewFileKeyManager(path string, consentKID string) (*FileKeyManager, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	return &FileKeyManager{ConsentKID: consentKID, set: set}, nil
+}
+
+func (f *FileKeyManager) VerificationKey(kid string) (*rsa.PublicKey, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, k := range f.set.Keys {
+		if kid != "" && k.KeyID != kid {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case *rsa.PublicKey:
+			return key, nil
+		case *rsa.PrivateKey:
+			return &key.PublicKey, nil
+		}
+	}
+
+	return nil, ErrorNoKey
+}
+
+func (f *FileKeyManager) ConsentKey() (*rsa.PrivateKey, string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, k := range f.set.Keys {
+		if k.KeyID != f.ConsentKID {
+			continue
+		}
+		if priv, ok := k.Key.(*rsa.PrivateKey); ok {
+			return priv, k.KeyID, nil
+		}
+	}
+
+	return nil, "", ErrorNoKey
+}
+
+func (f *FileKeyManager) PublicJWKS() (*jose.JSONWebKeySet, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	jwks := new(jose.JSONWebKeySet)
+	for _, k := range f.set.Keys {
+		if priv, ok := k.Key.(*rsa.PrivateKey); ok {
+			jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: &priv.PublicKey, KeyID: k.KeyID, Use: "sig", Algorithm: "RS256"})
+			continue
+		}
+		if pub := k.Public(); pub.Key != nil {
+			jwks.Keys = append(jwks.Keys, pub)
+		}
+	}
+
+	return jwks, nil
+}
+
+// MemoryKeyManager generates an RSA key on startup and never talks to
+// Hydra, which is handy for tests and standalone deployments. Call Rotate
+// to generate a new signing key; the previous one's public half stays
+// valid for RotationGrace so challenge JWTs it already signed still
+// verify.
+//
+// TODO: generate ECDSA keys too, once challenge/consent signing supports a
+// method other than RS256.
+type MemoryKeyManager struct {
+	RotationGrace time.Duration
+
+	mu         sync.RWMutex
+	current    *rsa.PrivateKey
+	currentKID string
+	retired    map[string]retiredKey
+}
+
+type retiredKey struct {
+	key     *rsa.PublicKey
+	expires time.Time
+}
+
+func NewMemoryKeyManager() (*MemoryKeyManager, error) {
+	m := &MemoryKeyManager{
+		RotationGrace: 24 * time.Hour,
+		retired:       make(map[string]retiredKey),
+	}
+
+	if err := m.Rotate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Rotate generates a new signing key and retires the previous one.
+func (m *MemoryKeyManager) Rotate() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil {
+		m.retired[m.currentKID] = retiredKey{
+			key:     &m.current.PublicKey,
+			expires: time.Now().Add(m.RotationGrace),
+		}
+	}
+
+	m.current = key
+	m.currentKID = fmt.Sprintf("mem-%d", time.Now().UnixNano())
+
+	return nil
+}
+
+func (m *MemoryKeyManager) VerificationKey(kid string) (*rsa.PublicKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if kid == "" || kid == m.currentKID {
+		return &m.current.PublicKey, nil
+	}
+
+	if retired, ok := m.retired[kid]; ok && time.Now().Before(retired.expires) {
+		return retired.key, nil
+	}
+
+	return nil, ErrorNoKey
+}
+
+func (m *MemoryKeyManager) ConsentKey() (*rsa.PrivateKey, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.current, m.currentKID, nil
+}
+
+func (m *MemoryKeyManager) PublicJWKS() (*jose.JSONWebKeySet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jwks := &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &m.current.PublicKey, KeyID: m.currentKID, Use: "sig", Algorithm: "RS256"},
+		},
+	}
+
+	now := time.Now()
+	for kid, retired := range m.retired {
+		if now.Before(retired.expires) {
+			jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: retired.key, KeyID: kid, Use: "sig", Algorithm: "RS256"})
+		}
+	}
+
+	return jwks, nil
+}
@@ -5,15 +5,55 @@ import (
 	"github.com/janekolszak/idp/helpers"
 
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// defaultMaxFailures is how many consecutive failures, from either the
+// same IP or the same username, turn ErrorAuthenticationFailure into
+// ErrorAccountLocked.
+const defaultMaxFailures = 5
+
+// defaultLockoutDuration is how long a key stays locked once it crosses
+// MaxFailures, independently of (and for much longer than) the per-attempt
+// backoff enforced between individual retries.
+const defaultLockoutDuration = 15 * time.Minute
+
 // Basic Authentication checker.
 // Expects Storage to return plain text passwords
 type BasicAuth struct {
 	Htpasswd helpers.Htpasswd
 	Realm    string
+
+	// Verifiers tries each hash format in turn to match the user's stored
+	// hash. Defaults to DefaultPasswordVerifiers, so bcrypt, argon2id,
+	// scrypt and SHA-512 crypt hashes are all accepted from the same
+	// htpasswd file.
+	Verifiers []PasswordVerifier
+
+	// Lockout tracks failed attempts, independently per source IP and per
+	// username. Defaults to an in-memory store.
+	Lockout LockoutStore
+
+	// MaxFailures is how many consecutive failures, from either the IP or
+	// the username, return ErrorAccountLocked. Defaults to 5.
+	MaxFailures int
+
+	// LockoutDuration is how long a key stays locked once it crosses
+	// MaxFailures. Defaults to 15 minutes.
+	LockoutDuration time.Duration
+
+	// ClientIPHeader, if set, names the header (e.g. "X-Forwarded-For") a
+	// trusted reverse proxy sets to the real client IP. Leave it unset
+	// only when BasicAuth sees connections directly, without a proxy in
+	// front of it - otherwise every client shares the proxy's RemoteAddr
+	// and the per-IP lockout bucket is meaningless.
+	ClientIPHeader string
+
+	// Audit, if set, is notified of every authentication attempt.
+	Audit core.AuditLogger
 }
 
 func NewBasicAuth(htpasswdFileName string, realm string) (*BasicAuth, error) {
@@ -25,6 +65,10 @@ func NewBasicAuth(htpasswdFileName string, realm string) (*BasicAuth, error) {
 	}
 
 	b.Realm = realm
+	b.Verifiers = DefaultPasswordVerifiers
+	b.Lockout = NewMemoryLockoutStore()
+	b.MaxFailures = defaultMaxFailures
+	b.LockoutDuration = defaultLockoutDuration
 
 	return b, nil
 }
@@ -36,20 +80,140 @@ func (c *BasicAuth) Check(r *http.Request) (user string, err error) {
 		return
 	}
 
-	hash, err := c.Htpasswd.Get(user)
-	if err != nil {
-		// Prevent timing attack
-		bcrypt.CompareHashAndPassword([]byte{}, []byte(pass))
+	ipKey := "ip:" + c.remoteIP(r)
+	userKey := "user:" + user
+
+	if err = c.throttled(ipKey); err != nil {
+		c.audit(r, user, "auth.failure", err)
+		return "", err
+	}
+	if err = c.throttled(userKey); err != nil {
+		c.audit(r, user, "auth.failure", err)
+		return "", err
+	}
+
+	hash, lookupErr := c.Htpasswd.Get(user)
+	if lookupErr != nil {
+		// Prevent a timing attack from revealing unknown usernames: always
+		// run a real hash comparison, just against a hash nobody's
+		// password will match.
+		hash = dummyHash
+	}
+
+	verifyErr := c.verify(hash, pass)
+
+	if lookupErr != nil || verifyErr != nil {
 		err = core.ErrorAuthenticationFailure
+
+		// Record both failures unconditionally: with "||", the moment the
+		// IP crosses MaxFailures the username would stop being recorded on
+		// every later attempt from that IP, defeating independent per-IP
+		// and per-username tracking.
+		lockedIP := c.recordFailure(ipKey)
+		lockedUser := c.recordFailure(userKey)
+		if lockedIP || lockedUser {
+			err = core.ErrorAccountLocked
+		}
+
+		c.audit(r, user, "auth.failure", err)
+		return "", err
+	}
+
+	c.Lockout.Reset(ipKey)
+	c.Lockout.Reset(userKey)
+	c.audit(r, user, "auth.success", nil)
+
+	return user, nil
+}
+
+// throttled returns ErrorAccountLocked if key is still within a Lock set by
+// a previous recordFailure, or ErrorAuthenticationFailure if key is merely
+// still inside the short backoff window from its last recorded failure -
+// so a retry made during backoff is rejected without costing another
+// failed attempt.
+func (c *BasicAuth) throttled(key string) error {
+	if locked, _, err := c.Lockout.Locked(key); err == nil && locked {
+		return core.ErrorAccountLocked
+	}
+
+	throttled, _, _, err := c.Lockout.Throttled(key)
+	if err != nil || !throttled {
+		return nil
+	}
+
+	return core.ErrorAuthenticationFailure
+}
+
+// recordFailure registers a failed attempt for key and, once it has
+// crossed MaxFailures, locks key for LockoutDuration - independently of
+// the short per-attempt backoff RecordFailure also sets. It reports
+// whether key is now locked.
+func (c *BasicAuth) recordFailure(key string) bool {
+	failures, _, err := c.Lockout.RecordFailure(key)
+	if err != nil {
+		return false
+	}
+
+	if failures < c.MaxFailures {
+		return false
+	}
+
+	c.Lockout.Lock(key, c.lockoutDuration())
+	return true
+}
+
+func (c *BasicAuth) lockoutDuration() time.Duration {
+	if c.LockoutDuration == 0 {
+		return defaultLockoutDuration
+	}
+	return c.LockoutDuration
+}
+
+func (c *BasicAuth) verify(hash, password string) error {
+	for _, verifier := range c.Verifiers {
+		if verifier.Matches(hash) {
+			return verifier.Verify(hash, password)
+		}
+	}
+
+	return fmt.Errorf("unrecognized password hash format")
+}
+
+func (c *BasicAuth) audit(r *http.Request, user string, eventType string, err error) {
+	if c.Audit == nil {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+	c.Audit.Audit(core.AuditEvent{
+		Type:     eventType,
+		Provider: "BasicAuth",
+		User:     user,
+		Remote:   r.RemoteAddr,
+		Err:      err,
+	})
+}
+
+// remoteIP returns the address the per-IP lockout bucket should key on:
+// the first address in ClientIPHeader, if set and present, otherwise the
+// host part of r.RemoteAddr. ClientIPHeader must only be trusted when
+// BasicAuth sits behind a proxy that sets it itself and strips any
+// client-supplied copy, or a client can forge it to frame another IP or
+// spread its attempts across unlimited ones.
+func (c *BasicAuth) remoteIP(r *http.Request) string {
+	if c.ClientIPHeader != "" {
+		if v := r.Header.Get(c.ClientIPHeader); v != "" {
+			if ip := strings.TrimSpace(strings.Split(v, ",")[0]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		err = core.ErrorAuthenticationFailure
+		return r.RemoteAddr
 	}
 
-	return
+	return host
 }
 
 func (c *BasicAuth) Respond(w http.ResponseWriter, r *http.Request) error {
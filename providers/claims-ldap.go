@@ -0,0 +1,74 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/janekolszak/idp/core"
+	ldap "gopkg.in/ldap.v3"
+)
+
+// LDAPClaimsProvider looks up a user's profile in an LDAP directory,
+// mapping the standard attributes (mail, cn, givenName, sn, memberOf) onto
+// UserProfile.
+type LDAPClaimsProvider struct {
+	Address  string
+	BindDN   string
+	BindPass string
+	BaseDN   string
+
+	// UserFilter is the search filter used to find the entry, with %s
+	// replaced by the (escaped) username, e.g. "(uid=%s)".
+	UserFilter string
+}
+
+var _ core.IDTokenClaimsProvider = (*LDAPClaimsProvider)(nil)
+
+func NewLDAPClaimsProvider(address, bindDN, bindPass, baseDN, userFilter string) *LDAPClaimsProvider {
+	return &LDAPClaimsProvider{
+		Address:    address,
+		BindDN:     bindDN,
+		BindPass:   bindPass,
+		BaseDN:     baseDN,
+		UserFilter: userFilter,
+	}
+}
+
+func (p *LDAPClaimsProvider) Claims(user string, scopes []string) (map[string]interface{}, error) {
+	conn, err := ldap.DialURL(p.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(p.BindDN, p.BindPass); err != nil {
+		return nil, err
+	}
+
+	request := ldap.NewSearchRequest(
+		p.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(p.UserFilter, ldap.EscapeFilter(user)),
+		[]string{"mail", "cn", "givenName", "sn", "memberOf"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("expected 1 LDAP entry for user %q, got %d", user, len(result.Entries))
+	}
+	entry := result.Entries[0]
+
+	profile := UserProfile{
+		Name:       entry.GetAttributeValue("cn"),
+		GivenName:  entry.GetAttributeValue("givenName"),
+		FamilyName: entry.GetAttributeValue("sn"),
+		Email:      entry.GetAttributeValue("mail"),
+		Groups:     entry.GetAttributeValues("memberOf"),
+	}
+	profile.EmailVerified = profile.Email != ""
+
+	return ScopeClaims(scopes, profile), nil
+}
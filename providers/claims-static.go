@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/janekolszak/idp/core"
+)
+
+// StaticClaimsProvider reads user profiles from the comment field of an
+// htpasswd file, e.g. a line like:
+//
+//	alice:$2y$10$...:name=Alice Smith;email=alice@example.com;groups=admin,dev
+//
+// It suits small, static deployments that don't have a directory to look
+// profiles up in.
+type StaticClaimsProvider struct {
+	profiles map[string]UserProfile
+}
+
+var _ core.IDTokenClaimsProvider = (*StaticClaimsProvider)(nil)
+
+func NewStaticClaimsProvider(htpasswdFileName string) (*StaticClaimsProvider, error) {
+	file, err := os.Open(htpasswdFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := &StaticClaimsProvider{profiles: make(map[string]UserProfile)}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 3 {
+			continue
+		}
+
+		p.profiles[fields[0]] = parseProfileComment(fields[2])
+	}
+
+	return p, scanner.Err()
+}
+
+func parseProfileComment(comment string) UserProfile {
+	var profile UserProfile
+
+	for _, pair := range strings.Split(comment, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "name":
+			profile.Name = kv[1]
+		case "family_name":
+			profile.FamilyName = kv[1]
+		case "given_name":
+			profile.GivenName = kv[1]
+		case "preferred_username":
+			profile.PreferredUsername = kv[1]
+		case "email":
+			profile.Email = kv[1]
+			profile.EmailVerified = true
+		case "groups":
+			profile.Groups = strings.Split(kv[1], ",")
+		case "roles":
+			profile.Roles = strings.Split(kv[1], ",")
+		}
+	}
+
+	return profile
+}
+
+// Claims returns scopes's claims for user's profile comment, or an empty set
+// if the htpasswd line for user had none - an authenticated user shouldn't
+// be refused consent just because they never got a profile comment.
+func (p *StaticClaimsProvider) Claims(user string, scopes []string) (map[string]interface{}, error) {
+	return ScopeClaims(scopes, p.profiles[user]), nil
+}
@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/janekolszak/idp/core"
+)
+
+// UserinfoClaimsProvider fetches a user's profile from an HTTP/JSON
+// userinfo backend, e.g. an internal user service.
+type UserinfoClaimsProvider struct {
+	// Endpoint is queried as Endpoint?user=<user>, and must return a JSON
+	// object whose fields match UserProfile's json tags.
+	Endpoint string
+	Client   *http.Client
+}
+
+var _ core.IDTokenClaimsProvider = (*UserinfoClaimsProvider)(nil)
+
+func NewUserinfoClaimsProvider(endpoint string) *UserinfoClaimsProvider {
+	return &UserinfoClaimsProvider{
+		Endpoint: endpoint,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (p *UserinfoClaimsProvider) Claims(user string, scopes []string) (map[string]interface{}, error) {
+	resp, err := p.Client.Get(p.Endpoint + "?user=" + url.QueryEscape(user))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo backend returned %s", resp.Status)
+	}
+
+	var profile UserProfile
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return nil, err
+	}
+
+	return ScopeClaims(scopes, profile), nil
+}
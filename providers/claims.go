@@ -0,0 +1,59 @@
+package providers
+
+// UserProfile holds the raw attributes a claims provider looked up for a
+// user, before ScopeClaims filters them down to whatever scopes the
+// client was actually granted.
+type UserProfile struct {
+	Name              string   `json:"name,omitempty"`
+	FamilyName        string   `json:"family_name,omitempty"`
+	GivenName         string   `json:"given_name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+}
+
+// ScopeClaims maps profile's fields onto the standard OIDC scopes: profile
+// -> name/family_name/given_name/preferred_username, email ->
+// email/email_verified, groups/roles -> their arrays. Scopes the profile
+// has no data for are simply omitted.
+func ScopeClaims(scopes []string, profile UserProfile) map[string]interface{} {
+	claims := make(map[string]interface{})
+
+	for _, scope := range scopes {
+		switch scope {
+		case "profile":
+			if profile.Name != "" {
+				claims["name"] = profile.Name
+			}
+			if profile.FamilyName != "" {
+				claims["family_name"] = profile.FamilyName
+			}
+			if profile.GivenName != "" {
+				claims["given_name"] = profile.GivenName
+			}
+			if profile.PreferredUsername != "" {
+				claims["preferred_username"] = profile.PreferredUsername
+			}
+
+		case "email":
+			if profile.Email != "" {
+				claims["email"] = profile.Email
+				claims["email_verified"] = profile.EmailVerified
+			}
+
+		case "groups":
+			if len(profile.Groups) > 0 {
+				claims["groups"] = profile.Groups
+			}
+
+		case "roles":
+			if len(profile.Roles) > 0 {
+				claims["roles"] = profile.Roles
+			}
+		}
+	}
+
+	return claims
+}
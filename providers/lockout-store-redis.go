@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisLockoutStore is a LockoutStore backed by Redis, for sharing failure
+// counts and backoff windows across multiple IDP instances. The failure
+// count itself never expires on its own; only Reset clears it.
+type RedisLockoutStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func NewRedisLockoutStore(client *redis.Client, prefix string) *RedisLockoutStore {
+	return &RedisLockoutStore{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+func (s *RedisLockoutStore) failuresKey(key string) string {
+	return s.Prefix + "failures:" + key
+}
+
+func (s *RedisLockoutStore) backoffKey(key string) string {
+	return s.Prefix + "backoff:" + key
+}
+
+func (s *RedisLockoutStore) lockKey(key string) string {
+	return s.Prefix + "locked:" + key
+}
+
+func (s *RedisLockoutStore) RecordFailure(key string) (int, time.Duration, error) {
+	failures, err := s.Client.Incr(s.failuresKey(key)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	doublings := failures
+	if doublings > maxBackoffDoublings {
+		doublings = maxBackoffDoublings
+	}
+	retryAfter := time.Duration(1<<uint(doublings)) * time.Second
+
+	if err := s.Client.Set(s.backoffKey(key), "1", retryAfter).Err(); err != nil {
+		return int(failures), retryAfter, err
+	}
+
+	return int(failures), retryAfter, nil
+}
+
+func (s *RedisLockoutStore) Throttled(key string) (bool, int, time.Duration, error) {
+	failures, err := s.Client.Get(s.failuresKey(key)).Int()
+	if err != nil && err != redis.Nil {
+		return false, 0, 0, err
+	}
+
+	ttl, err := s.Client.TTL(s.backoffKey(key)).Result()
+	if err != nil {
+		return false, failures, 0, err
+	}
+
+	if ttl <= 0 {
+		return false, failures, 0, nil
+	}
+
+	return true, failures, ttl, nil
+}
+
+func (s *RedisLockoutStore) Lock(key string, duration time.Duration) error {
+	return s.Client.Set(s.lockKey(key), "1", duration).Err()
+}
+
+func (s *RedisLockoutStore) Locked(key string) (bool, time.Duration, error) {
+	ttl, err := s.Client.TTL(s.lockKey(key)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	if ttl <= 0 {
+		return false, 0, nil
+	}
+
+	return true, ttl, nil
+}
+
+func (s *RedisLockoutStore) Reset(key string) error {
+	return s.Client.Del(s.failuresKey(key), s.backoffKey(key), s.lockKey(key)).Err()
+}
@@ -0,0 +1,136 @@
+package providers
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBackoffDoublings caps the exponential backoff LockoutStore
+// implementations apply, so a very persistent attacker doesn't end up
+// waiting (or making legitimate users wait) for days.
+const maxBackoffDoublings = 10
+
+// LockoutStore tracks failed login attempts per key (provider-chosen,
+// typically "ip:<addr>" or "user:<name>"). It gives BasicAuth two
+// independent mechanisms: a short exponential backoff between consecutive
+// attempts, and a separate, fixed-length Lock the caller applies once a key
+// has crossed its own failure threshold.
+type LockoutStore interface {
+	// RecordFailure registers a failed attempt for key, returning the
+	// total number of consecutive failures seen since the last Reset and
+	// the backoff duration the caller should wait before key's next
+	// attempt.
+	RecordFailure(key string) (failures int, retryAfter time.Duration, err error)
+
+	// Throttled reports whether key is still within a backoff window set
+	// by a previous RecordFailure, the failure count that window belongs
+	// to, and how much longer it lasts.
+	Throttled(key string) (throttled bool, failures int, retryAfter time.Duration, err error)
+
+	// Lock locks key for duration, independently of its backoff window.
+	Lock(key string, duration time.Duration) error
+
+	// Locked reports whether key is still within a window set by a
+	// previous Lock, and how much longer it lasts.
+	Locked(key string) (locked bool, retryAfter time.Duration, err error)
+
+	// Reset clears key's failure count and any lock, e.g. after a
+	// successful login.
+	Reset(key string) error
+}
+
+type lockoutEntry struct {
+	failures     int
+	backoffUntil time.Time
+	lockedUntil  time.Time
+}
+
+// MemoryLockoutStore is the default LockoutStore, backed by a map. It
+// doesn't share state across instances, so a multi-instance deployment
+// should use a shared store instead.
+type MemoryLockoutStore struct {
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+func NewMemoryLockoutStore() *MemoryLockoutStore {
+	return &MemoryLockoutStore{
+		entries: make(map[string]*lockoutEntry),
+	}
+}
+
+func (s *MemoryLockoutStore) entry(key string) *lockoutEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		e = &lockoutEntry{}
+		s.entries[key] = e
+	}
+	return e
+}
+
+func (s *MemoryLockoutStore) RecordFailure(key string) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key)
+	e.failures++
+
+	doublings := e.failures
+	if doublings > maxBackoffDoublings {
+		doublings = maxBackoffDoublings
+	}
+	retryAfter := time.Duration(1<<uint(doublings)) * time.Second
+	e.backoffUntil = time.Now().Add(retryAfter)
+
+	return e.failures, retryAfter, nil
+}
+
+func (s *MemoryLockoutStore) Throttled(key string) (bool, int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return false, 0, 0, nil
+	}
+
+	retryAfter := time.Until(e.backoffUntil)
+	if retryAfter <= 0 {
+		return false, e.failures, 0, nil
+	}
+
+	return true, e.failures, retryAfter, nil
+}
+
+func (s *MemoryLockoutStore) Lock(key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entry(key).lockedUntil = time.Now().Add(duration)
+	return nil
+}
+
+func (s *MemoryLockoutStore) Locked(key string) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return false, 0, nil
+	}
+
+	retryAfter := time.Until(e.lockedUntil)
+	if retryAfter <= 0 {
+		return false, 0, nil
+	}
+
+	return true, retryAfter, nil
+}
+
+func (s *MemoryLockoutStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
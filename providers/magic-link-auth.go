@@ -0,0 +1,235 @@
+package providers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/janekolszak/idp/core"
+)
+
+// TokenStore tracks the one-time tokens (jti claims) issued by MagicLinkAuth
+// so that a link can only ever be followed once.
+type TokenStore interface {
+	// Store remembers a freshly issued jti until it expires.
+	Store(jti string, expires time.Time) error
+
+	// Consume atomically marks jti as used. It returns an error if the jti
+	// is unknown, already consumed, or expired, so that replayed links are
+	// rejected.
+	Consume(jti string) error
+}
+
+// Mailer delivers the magic link to the user.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+var defaultMagicLinkForm = template.Must(template.New("magic-link").Parse(`
+<!DOCTYPE html>
+<html>
+<body>
+	<form method="POST">
+		<input type="email" name="email" placeholder="you@example.com" required>
+		<button type="submit">Send me a sign-in link</button>
+	</form>
+</body>
+</html>
+`))
+
+// MagicLinkAuth authenticates users by emailing them a signed, single-use
+// link instead of asking for a password.
+type MagicLinkAuth struct {
+	// Realm mirrors BasicAuth.Realm. MagicLinkAuth does not display it:
+	// neither the form nor the emailed message includes it.
+	Realm string
+
+	// LinkBaseURL is the public URL the signed token is appended to, e.g.
+	// "https://idp/magic".
+	LinkBaseURL string
+
+	// SigningKey authenticates the tokens this provider issues. It never
+	// leaves the provider, unlike the consent keys managed by core.IDP.
+	SigningKey []byte
+
+	// TTL is how long an issued link remains valid. Defaults to 10 minutes.
+	TTL time.Duration
+
+	// Store records issued jtis and rejects replays. Defaults to an
+	// in-memory store.
+	Store TokenStore
+
+	// Mailer delivers the link. Required.
+	Mailer Mailer
+
+	// Form renders the "enter your email" page. Defaults to a minimal
+	// built-in template.
+	Form *template.Template
+}
+
+// NewMagicLinkAuth creates a MagicLinkAuth with the in-memory TokenStore and
+// default form/TTL.
+func NewMagicLinkAuth(realm, linkBaseURL string, signingKey []byte, mailer Mailer) *MagicLinkAuth {
+	return &MagicLinkAuth{
+		Realm:       realm,
+		LinkBaseURL: linkBaseURL,
+		SigningKey:  signingKey,
+		TTL:         10 * time.Minute,
+		Store:       NewMemoryTokenStore(),
+		Mailer:      mailer,
+		Form:        defaultMagicLinkForm,
+	}
+}
+
+// Check verifies a token from a followed magic link and returns the email
+// address it was issued for.
+func (m *MagicLinkAuth) Check(r *http.Request) (user string, err error) {
+	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		err = core.ErrorAuthenticationFailure
+		return
+	}
+
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return m.SigningKey, nil
+	})
+	if err != nil || !token.Valid {
+		err = core.ErrorAuthenticationFailure
+		return
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		err = core.ErrorAuthenticationFailure
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	if jti == "" || sub == "" {
+		err = core.ErrorAuthenticationFailure
+		return
+	}
+
+	// Consume is atomic, so a second request with the same link fails here.
+	if err = m.Store.Consume(jti); err != nil {
+		err = core.ErrorAuthenticationFailure
+		return
+	}
+
+	user = sub
+	return
+}
+
+// Respond shows the "enter your email" form, or, on POST, signs and emails
+// a fresh magic link.
+func (m *MagicLinkAuth) Respond(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return m.Form.Execute(w, nil)
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return core.ErrorBadRequest
+	}
+
+	link, err := m.issueLink(email)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Click the link below to sign in:\n\n%s\n\nThe link expires in %s.", link, m.ttl())
+	if err := m.Mailer.Send(email, "Your sign-in link", body); err != nil {
+		return err
+	}
+
+	http.Error(w, "Check your email for a sign-in link", http.StatusOK)
+	return nil
+}
+
+func (m *MagicLinkAuth) issueLink(email string) (string, error) {
+	jti, err := randomJTI()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(m.ttl())
+	claims := jwt.MapClaims{
+		"sub": email,
+		"jti": jti,
+		"exp": expires.Unix(),
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.SigningKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.Store.Store(jti, expires); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s?token=%s", m.LinkBaseURL, signed), nil
+}
+
+func (m *MagicLinkAuth) ttl() time.Duration {
+	if m.TTL == 0 {
+		return 10 * time.Minute
+	}
+	return m.TTL
+}
+
+func randomJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// MemoryTokenStore is the default TokenStore, backed by a map. It is not
+// suitable for multi-instance deployments since issued jtis aren't shared.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryTokenStore) Store(jti string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[jti] = expires
+	return nil
+}
+
+func (s *MemoryTokenStore) Consume(jti string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.tokens[jti]
+	if !ok {
+		return fmt.Errorf("token unknown or already consumed")
+	}
+	delete(s.tokens, jti)
+
+	if time.Now().After(expires) {
+		return fmt.Errorf("token expired")
+	}
+
+	return nil
+}
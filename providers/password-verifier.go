@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	crypt "github.com/GehirnInc/crypt"
+	_ "github.com/GehirnInc/crypt/sha512_crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// dummyHash is compared against whenever a user is unknown, so that an
+// unknown username costs a real hash comparison rather than returning
+// early. It is a single bcrypt (cost 10) hash, whatever format the known
+// users' hashes are in, so the timing only matches a wrong password for
+// users whose stored hash is bcrypt at cost 10.
+const dummyHash = "$2a$10$CwTycUXWue0Thq9StjUM0uJ8Lhn3QVL3dQ1bh5t1Dpnqbqxl6Odgq"
+
+// PasswordVerifier checks a plaintext password against one hash format.
+type PasswordVerifier interface {
+	// Matches reports whether hash looks like a hash this verifier
+	// understands.
+	Matches(hash string) bool
+
+	// Verify checks password against hash, returning nil only on a match.
+	Verify(hash, password string) error
+}
+
+// DefaultPasswordVerifiers covers every hash format htpasswd commonly
+// produces.
+var DefaultPasswordVerifiers = []PasswordVerifier{
+	BcryptVerifier{},
+	Argon2idVerifier{},
+	ScryptVerifier{},
+	SHA512CryptVerifier{},
+}
+
+// BcryptVerifier handles the $2a$/$2b$/$2y$ bcrypt hashes htpasswd -B
+// produces.
+type BcryptVerifier struct{}
+
+func (BcryptVerifier) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (BcryptVerifier) Verify(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// Argon2idVerifier handles hashes in the reference argon2 encoding:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<hash>
+type Argon2idVerifier struct{}
+
+func (Argon2idVerifier) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (Argon2idVerifier) Verify(hash, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return fmt.Errorf("invalid argon2id hash")
+	}
+
+	var memory, time uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &parallelism); err != nil {
+		return fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return err
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, parallelism, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return fmt.Errorf("argon2id hash mismatch")
+	}
+
+	return nil
+}
+
+// ScryptVerifier handles hashes in the encoding:
+// $scrypt$ln=<log2N>,r=<r>,p=<p>$<salt>$<hash>
+type ScryptVerifier struct{}
+
+func (ScryptVerifier) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$scrypt$")
+}
+
+func (ScryptVerifier) Verify(hash, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return fmt.Errorf("invalid scrypt hash")
+	}
+
+	var logN, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return fmt.Errorf("invalid scrypt parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return err
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return err
+	}
+
+	got, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, len(want))
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return fmt.Errorf("scrypt hash mismatch")
+	}
+
+	return nil
+}
+
+// SHA512CryptVerifier handles the $6$ crypt(3) hashes htpasswd -6
+// produces.
+type SHA512CryptVerifier struct{}
+
+func (SHA512CryptVerifier) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$6$")
+}
+
+func (SHA512CryptVerifier) Verify(hash, password string) error {
+	return crypt.SHA512.New().Verify(hash, []byte(password))
+}
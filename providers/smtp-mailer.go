@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+// SMTPMailer is the default Mailer, sending plain-text mail through an SMTP
+// relay.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from string, auth smtp.Auth) *SMTPMailer {
+	return &SMTPMailer{
+		Addr: addr,
+		From: from,
+		Auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.From, to, subject, body)
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(msg))
+}
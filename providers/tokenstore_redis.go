@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisTokenStore is a TokenStore backed by Redis, suitable for sharing
+// consumed jtis across multiple IDP instances. It is not wired up by
+// default; construct one explicitly and assign it to MagicLinkAuth.Store.
+type RedisTokenStore struct {
+	Client *redis.Client
+
+	// Prefix namespaces the keys this store writes, in case the database is
+	// shared with other applications.
+	Prefix string
+}
+
+func NewRedisTokenStore(client *redis.Client, prefix string) *RedisTokenStore {
+	return &RedisTokenStore{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+func (s *RedisTokenStore) key(jti string) string {
+	return s.Prefix + jti
+}
+
+func (s *RedisTokenStore) Store(jti string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return fmt.Errorf("token already expired")
+	}
+
+	return s.Client.Set(s.key(jti), "pending", ttl).Err()
+}
+
+func (s *RedisTokenStore) Consume(jti string) error {
+	n, err := s.Client.Del(s.key(jti)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("token unknown, already consumed, or expired")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package providers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLTokenStore is a TokenStore backed by a SQL database. It is not wired
+// up by default; construct one explicitly and assign it to
+// MagicLinkAuth.Store. The table is expected to look like:
+//
+//	CREATE TABLE magic_link_tokens (
+//		jti     VARCHAR(255) PRIMARY KEY,
+//		expires TIMESTAMP NOT NULL
+//	);
+type SQLTokenStore struct {
+	DB    *sql.DB
+	Table string
+}
+
+func NewSQLTokenStore(db *sql.DB, table string) *SQLTokenStore {
+	return &SQLTokenStore{
+		DB:    db,
+		Table: table,
+	}
+}
+
+func (s *SQLTokenStore) Store(jti string, expires time.Time) error {
+	_, err := s.DB.Exec(
+		fmt.Sprintf("INSERT INTO %s (jti, expires) VALUES ($1, $2)", s.Table),
+		jti, expires,
+	)
+	return err
+}
+
+func (s *SQLTokenStore) Consume(jti string) error {
+	result, err := s.DB.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE jti = $1 AND expires > $2", s.Table),
+		jti, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("token unknown, already consumed, or expired")
+	}
+
+	return nil
+}
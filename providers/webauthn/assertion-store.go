@@ -0,0 +1,78 @@
+package webauthn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AssertionStore tracks completed-but-not-yet-consumed WebAuthn assertions,
+// so a session cookie can carry an opaque nonce instead of the user handle
+// itself, and Check can invalidate the assertion server-side once it's been
+// used - even though it only has a *http.Request, not a ResponseWriter, to
+// update the cookie with.
+type AssertionStore interface {
+	// Store remembers handle under nonce until it expires.
+	Store(nonce string, handle []byte, expires time.Time) error
+
+	// Consume atomically returns and forgets the handle stored under nonce.
+	// It returns an error if nonce is unknown, already consumed, or
+	// expired, so a replayed nonce is rejected.
+	Consume(nonce string) ([]byte, error)
+}
+
+// randomNonce generates an unguessable, URL-safe token to key an
+// AssertionStore entry.
+func randomNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type assertionEntry struct {
+	handle  []byte
+	expires time.Time
+}
+
+// MemoryAssertionStore is the default AssertionStore, backed by a map. It
+// is not suitable for multi-instance deployments since a nonce consumed on
+// one instance isn't visible to the others.
+type MemoryAssertionStore struct {
+	mu      sync.Mutex
+	entries map[string]assertionEntry
+}
+
+func NewMemoryAssertionStore() *MemoryAssertionStore {
+	return &MemoryAssertionStore{
+		entries: make(map[string]assertionEntry),
+	}
+}
+
+func (s *MemoryAssertionStore) Store(nonce string, handle []byte, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[nonce] = assertionEntry{handle: handle, expires: expires}
+	return nil
+}
+
+func (s *MemoryAssertionStore) Consume(nonce string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[nonce]
+	if !ok {
+		return nil, fmt.Errorf("assertion unknown or already consumed")
+	}
+	delete(s.entries, nonce)
+
+	if time.Now().After(e.expires) {
+		return nil, fmt.Errorf("assertion expired")
+	}
+
+	return e.handle, nil
+}
@@ -0,0 +1,86 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	bolt "github.com/boltdb/bolt"
+)
+
+var credentialsBucket = []byte("webauthn_credentials")
+
+// BoltCredentialStore persists credentials in a BoltDB file, keyed by
+// credential ID.
+type BoltCredentialStore struct {
+	DB *bolt.DB
+}
+
+func NewBoltCredentialStore(db *bolt.DB) (*BoltCredentialStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(credentialsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltCredentialStore{DB: db}, nil
+}
+
+func (s *BoltCredentialStore) Save(cred *Credential) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(cred)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(credentialsBucket).Put(cred.ID, data)
+	})
+}
+
+func (s *BoltCredentialStore) ByID(credentialID []byte) (*Credential, error) {
+	var cred Credential
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(credentialsBucket).Get(credentialID)
+		if data == nil {
+			return fmt.Errorf("credential not found")
+		}
+		return json.Unmarshal(data, &cred)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+func (s *BoltCredentialStore) ByUserHandle(userHandle []byte) ([]*Credential, error) {
+	var creds []*Credential
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).ForEach(func(_, data []byte) error {
+			var cred Credential
+			if err := json.Unmarshal(data, &cred); err != nil {
+				return err
+			}
+			if bytes.Equal(cred.UserHandle, userHandle) {
+				creds = append(creds, &cred)
+			}
+			return nil
+		})
+	})
+
+	return creds, err
+}
+
+func (s *BoltCredentialStore) UpdateSignCount(credentialID []byte, count uint32) error {
+	cred, err := s.ByID(credentialID)
+	if err != nil {
+		return err
+	}
+
+	cred.SignCount = count
+
+	return s.Save(cred)
+}
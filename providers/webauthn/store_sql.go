@@ -0,0 +1,76 @@
+package webauthn
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SQLCredentialStore persists credentials in a SQL table shaped like:
+//
+//	CREATE TABLE webauthn_credentials (
+//		id          BYTEA PRIMARY KEY,
+//		user_handle BYTEA NOT NULL,
+//		public_key  BYTEA NOT NULL,
+//		sign_count  INTEGER NOT NULL,
+//		aaguid      BYTEA
+//	);
+type SQLCredentialStore struct {
+	DB    *sql.DB
+	Table string
+}
+
+func NewSQLCredentialStore(db *sql.DB, table string) *SQLCredentialStore {
+	return &SQLCredentialStore{DB: db, Table: table}
+}
+
+func (s *SQLCredentialStore) Save(cred *Credential) error {
+	_, err := s.DB.Exec(
+		fmt.Sprintf("INSERT INTO %s (id, user_handle, public_key, sign_count, aaguid) VALUES ($1, $2, $3, $4, $5)", s.Table),
+		cred.ID, cred.UserHandle, cred.PublicKey, cred.SignCount, cred.AAGUID,
+	)
+	return err
+}
+
+func (s *SQLCredentialStore) ByID(credentialID []byte) (*Credential, error) {
+	cred := &Credential{ID: credentialID}
+
+	row := s.DB.QueryRow(
+		fmt.Sprintf("SELECT user_handle, public_key, sign_count, aaguid FROM %s WHERE id = $1", s.Table),
+		credentialID,
+	)
+	if err := row.Scan(&cred.UserHandle, &cred.PublicKey, &cred.SignCount, &cred.AAGUID); err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+func (s *SQLCredentialStore) ByUserHandle(userHandle []byte) ([]*Credential, error) {
+	rows, err := s.DB.Query(
+		fmt.Sprintf("SELECT id, public_key, sign_count, aaguid FROM %s WHERE user_handle = $1", s.Table),
+		userHandle,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*Credential
+	for rows.Next() {
+		cred := &Credential{UserHandle: userHandle}
+		if err := rows.Scan(&cred.ID, &cred.PublicKey, &cred.SignCount, &cred.AAGUID); err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+
+	return creds, rows.Err()
+}
+
+func (s *SQLCredentialStore) UpdateSignCount(credentialID []byte, count uint32) error {
+	_, err := s.DB.Exec(
+		fmt.Sprintf("UPDATE %s SET sign_count = $1 WHERE id = $2", s.Table),
+		count, credentialID,
+	)
+	return err
+}
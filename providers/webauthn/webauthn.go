@@ -0,0 +1,333 @@
+// Package webauthn provides a WebAuthn/FIDO2 second-factor core.Authenticator
+// for core.IDP, modeled on the hydra-webauthn integration.
+package webauthn
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	duowebauthn "github.com/duo-labs/webauthn/webauthn"
+	"github.com/gorilla/sessions"
+	"github.com/janekolszak/idp/core"
+)
+
+const (
+	registrationSessionKey = "webauthn-registration"
+	loginSessionKey        = "webauthn-login"
+	assertedUserSessionKey = "webauthn-user"
+
+	// defaultAssertionTTL is how long a completed LoginFinish has to be
+	// picked up by Check before its nonce expires.
+	defaultAssertionTTL = 5 * time.Minute
+)
+
+func init() {
+	// The gorilla session store gob-encodes its Values under the hood; the
+	// default types it already knows about don't include duowebauthn's, so
+	// without this Save() fails the moment a ceremony is in progress.
+	gob.Register(&duowebauthn.SessionData{})
+}
+
+// Credential is the durable record CredentialStore keeps for one
+// registered authenticator.
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	UserHandle []byte
+}
+
+// CredentialStore persists WebAuthn credentials.
+type CredentialStore interface {
+	// Save stores a newly registered credential.
+	Save(cred *Credential) error
+
+	// ByID looks up a credential by its credential ID.
+	ByID(credentialID []byte) (*Credential, error)
+
+	// ByUserHandle lists every credential registered to a user handle.
+	ByUserHandle(userHandle []byte) ([]*Credential, error)
+
+	// UpdateSignCount persists the authenticator's new signature counter,
+	// so a cloned authenticator replaying an old counter can be detected.
+	UpdateSignCount(credentialID []byte, count uint32) error
+}
+
+// user adapts a user handle and its stored credentials to the interface
+// github.com/duo-labs/webauthn/webauthn expects.
+type user struct {
+	handle      []byte
+	name        string
+	credentials []duowebauthn.Credential
+}
+
+func (u *user) WebAuthnID() []byte                            { return u.handle }
+func (u *user) WebAuthnName() string                          { return u.name }
+func (u *user) WebAuthnDisplayName() string                   { return u.name }
+func (u *user) WebAuthnIcon() string                          { return "" }
+func (u *user) WebAuthnCredentials() []duowebauthn.Credential { return u.credentials }
+
+// Config mirrors duowebauthn.Config: the relying party's identity as seen
+// by the browser and the authenticators it talks to.
+type Config struct {
+	RelyingPartyID     string
+	RelyingPartyName   string
+	RelyingPartyOrigin string
+}
+
+// WebAuthnAuth is a core.Authenticator that fulfils a challenge with a
+// WebAuthn/FIDO2 assertion instead of (or, via core.Chain, in addition to)
+// a password.
+type WebAuthnAuth struct {
+	Store   CredentialStore
+	Session sessions.Store
+
+	// Assertions tracks completed login ceremonies between LoginFinish and
+	// Check, so an assertion can only ever be picked up once. Defaults to
+	// an in-memory store.
+	Assertions AssertionStore
+
+	// AssertionTTL is how long a completed LoginFinish has to be picked up
+	// by Check. Defaults to 5 minutes.
+	AssertionTTL time.Duration
+
+	webauthn *duowebauthn.WebAuthn
+}
+
+func NewWebAuthnAuth(config Config, store CredentialStore, session sessions.Store) (*WebAuthnAuth, error) {
+	w, err := duowebauthn.New(&duowebauthn.Config{
+		RPID:          config.RelyingPartyID,
+		RPDisplayName: config.RelyingPartyName,
+		RPOrigin:      config.RelyingPartyOrigin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnAuth{
+		Store:        store,
+		Session:      session,
+		Assertions:   NewMemoryAssertionStore(),
+		AssertionTTL: defaultAssertionTTL,
+		webauthn:     w,
+	}, nil
+}
+
+func (a *WebAuthnAuth) assertionTTL() time.Duration {
+	if a.AssertionTTL == 0 {
+		return defaultAssertionTTL
+	}
+	return a.AssertionTTL
+}
+
+func (a *WebAuthnAuth) userFor(handle []byte, name string) (*user, error) {
+	creds, err := a.Store.ByUserHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{handle: handle, name: name}
+	for _, c := range creds {
+		u.credentials = append(u.credentials, duowebauthn.Credential{
+			ID:        c.ID,
+			PublicKey: c.PublicKey,
+			Authenticator: duowebauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+
+	return u, nil
+}
+
+// RegisterBegin starts credential registration for userHandle/name,
+// returning the CredentialCreation options navigator.credentials.create()
+// needs.
+func (a *WebAuthnAuth) RegisterBegin(w http.ResponseWriter, r *http.Request, userHandle []byte, name string) error {
+	u, err := a.userFor(userHandle, name)
+	if err != nil {
+		return err
+	}
+
+	creation, sessionData, err := a.webauthn.BeginRegistration(u)
+	if err != nil {
+		return err
+	}
+
+	if err := a.putSession(w, r, registrationSessionKey, sessionData); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(creation)
+}
+
+// RegisterFinish verifies the browser's attestation response and stores
+// the resulting credential.
+func (a *WebAuthnAuth) RegisterFinish(w http.ResponseWriter, r *http.Request, userHandle []byte, name string) error {
+	sessionData, err := a.getSession(r, registrationSessionKey)
+	if err != nil {
+		return err
+	}
+
+	u, err := a.userFor(userHandle, name)
+	if err != nil {
+		return err
+	}
+
+	cred, err := a.webauthn.FinishRegistration(u, *sessionData, r)
+	if err != nil {
+		return err
+	}
+
+	return a.Store.Save(&Credential{
+		ID:         cred.ID,
+		PublicKey:  cred.PublicKey,
+		SignCount:  cred.Authenticator.SignCount,
+		AAGUID:     cred.Authenticator.AAGUID,
+		UserHandle: userHandle,
+	})
+}
+
+// LoginBegin starts an authentication ceremony for userHandle, returning
+// the CredentialAssertion options navigator.credentials.get() needs.
+func (a *WebAuthnAuth) LoginBegin(w http.ResponseWriter, r *http.Request, userHandle []byte, name string) error {
+	u, err := a.userFor(userHandle, name)
+	if err != nil {
+		return err
+	}
+
+	assertion, sessionData, err := a.webauthn.BeginLogin(u)
+	if err != nil {
+		return err
+	}
+
+	if err := a.putSession(w, r, loginSessionKey, sessionData); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(assertion)
+}
+
+// LoginFinish verifies the browser's assertion response, updates the
+// credential's signature counter, and stashes the authenticated user handle
+// in Assertions under a fresh nonce, which it remembers in the session so
+// Check can consume it exactly once.
+func (a *WebAuthnAuth) LoginFinish(w http.ResponseWriter, r *http.Request, userHandle []byte, name string) error {
+	sessionData, err := a.getSession(r, loginSessionKey)
+	if err != nil {
+		return err
+	}
+
+	u, err := a.userFor(userHandle, name)
+	if err != nil {
+		return err
+	}
+
+	cred, err := a.webauthn.FinishLogin(u, *sessionData, r)
+	if err != nil {
+		return err
+	}
+
+	if err := a.Store.UpdateSignCount(cred.ID, cred.Authenticator.SignCount); err != nil {
+		return err
+	}
+
+	nonce, err := randomNonce()
+	if err != nil {
+		return err
+	}
+
+	if err := a.Assertions.Store(nonce, userHandle, time.Now().Add(a.assertionTTL())); err != nil {
+		return err
+	}
+
+	return a.putSession(w, r, assertedUserSessionKey, nonce)
+}
+
+// Check consumes the nonce a completed LoginFinish stashed in the session,
+// resolving it to the asserted user handle via Assertions so the assertion
+// can't be replayed even though Check has no ResponseWriter to update the
+// session cookie with, fulfilling core.Authenticator.
+func (a *WebAuthnAuth) Check(r *http.Request) (user string, err error) {
+	session, err := a.Session.Get(r, assertedUserSessionKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, ok := session.Values[assertedUserSessionKey].(string)
+	if !ok {
+		return "", core.ErrorAuthenticationFailure
+	}
+
+	handle, err := a.Assertions.Consume(nonce)
+	if err != nil {
+		return "", core.ErrorAuthenticationFailure
+	}
+
+	return string(handle), nil
+}
+
+// Respond reports that no assertion has been completed yet. Callers drive
+// the register/login ceremonies through the handlers from Handler rather
+// than a redirect-based challenge.
+func (a *WebAuthnAuth) Respond(w http.ResponseWriter, r *http.Request) error {
+	http.Error(w, "webauthn assertion required", http.StatusUnauthorized)
+	return nil
+}
+
+// Handler mounts the register/login begin/finish endpoints under prefix
+// (e.g. "/webauthn"), keyed off the "user" query parameter.
+func (a *WebAuthnAuth) Handler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(prefix+"/register/begin", a.wrap(a.RegisterBegin))
+	mux.HandleFunc(prefix+"/register/finish", a.wrap(a.RegisterFinish))
+	mux.HandleFunc(prefix+"/login/begin", a.wrap(a.LoginBegin))
+	mux.HandleFunc(prefix+"/login/finish", a.wrap(a.LoginFinish))
+
+	return mux
+}
+
+func (a *WebAuthnAuth) wrap(fn func(w http.ResponseWriter, r *http.Request, userHandle []byte, name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("user")
+		if name == "" {
+			http.Error(w, "user is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := fn(w, r, []byte(name), name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
+func (a *WebAuthnAuth) putSession(w http.ResponseWriter, r *http.Request, key string, value interface{}) error {
+	session, err := a.Session.Get(r, key)
+	if err != nil {
+		return err
+	}
+
+	session.Values[key] = value
+
+	return session.Save(r, w)
+}
+
+func (a *WebAuthnAuth) getSession(r *http.Request, key string) (*duowebauthn.SessionData, error) {
+	session, err := a.Session.Get(r, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := session.Values[key].(*duowebauthn.SessionData)
+	if !ok {
+		return nil, fmt.Errorf("no in-progress webauthn ceremony")
+	}
+
+	return data, nil
+}